@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -9,7 +10,12 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+
+	"github.com/hashicorp/yamux"
+	"golang.org/x/term"
 )
 
 const (
@@ -21,8 +27,17 @@ const (
 	caFile         = "./cert/ca-cert.pem"
 )
 
+// ANSI color codes used to set stderr output apart from stdout.
+const (
+	ansiRed   = "\x1b[31m"
+	ansiReset = "\x1b[0m"
+)
+
+// Client multiplexes every command over one mux session opened on top of a
+// single TLS connection, so commands no longer serialize behind each other.
 type Client struct {
-	connection net.Conn
+	conn    net.Conn
+	session *yamux.Session
 }
 
 func NewClient(address string) (*Client, error) {
@@ -36,8 +51,15 @@ func NewClient(address string) (*Client, error) {
 		return nil, err
 	}
 
+	session, err := yamux.Client(conn, yamux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	return &Client{
-		connection: conn,
+		conn:    conn,
+		session: session,
 	}, nil
 }
 
@@ -62,41 +84,181 @@ func LoadCertificates() (*tls.Config, error) {
 	}, nil
 }
 
-func (c *Client) SendCommand(command string) {
-	_, err := fmt.Fprintf(c.connection, command+"\n")
+// streamStdin adapts a mux stream into an io.Writer that forwards every
+// Write as a stdin frame.
+type streamStdin struct {
+	stream net.Conn
+}
+
+func (w *streamStdin) Write(p []byte) (int, error) {
+	if err := writeFrame(w.stream, Frame{Type: "stdin", Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// RunCommand opens a new stream for cmd and runs it independently of any
+// other in-flight command. The returned writer forwards to the command's
+// stdin, the reader yields its combined stdout/stderr (stderr set apart in
+// red), and the channel receives the exit code (or -1 on a transport/server
+// error or timeout) once, then closes. Canceling ctx closes the stream and
+// ends the command early.
+func (c *Client) RunCommand(ctx context.Context, cmd string) (io.Writer, io.Reader, <-chan int, error) {
+	stream, err := c.session.Open()
 	if err != nil {
-		if err != nil && strings.Contains(err.Error(), "broken pipe") {
-			// Server closed the connection, trigger a panic.
-			log.Fatal("Connection to server closed")
+		return nil, nil, nil, err
+	}
+
+	if err := writeFrame(stream, Frame{Type: "exec", Cmd: cmd}); err != nil {
+		stream.Close()
+		return nil, nil, nil, err
+	}
+
+	pr, pw := io.Pipe()
+	exitCode := make(chan int, 1)
+
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	go func() {
+		defer stream.Close()
+		defer pw.Close()
+		defer close(exitCode)
+
+		dec := newFrameDecoder(stream)
+		for {
+			frame, err := readFrame(dec)
+			if err != nil {
+				exitCode <- -1
+				return
+			}
+
+			switch frame.Type {
+			case "stdout":
+				pw.Write(frame.Data)
+			case "stderr":
+				pw.Write([]byte(ansiRed))
+				pw.Write(frame.Data)
+				pw.Write([]byte(ansiReset))
+			case "exit":
+				code := 0
+				if frame.ExitCode != nil {
+					code = *frame.ExitCode
+				}
+				exitCode <- code
+				return
+			case "error":
+				pw.CloseWithError(fmt.Errorf("%s", frame.Data))
+				exitCode <- -1
+				return
+			case "timeout":
+				exitCode <- -1
+				return
+			}
 		}
-		log.Printf("Error while sending command: %v", err)
+	}()
+
+	return &streamStdin{stream: stream}, pr, exitCode, nil
+}
+
+// RunInteractive opens a new stream and requests a pty-backed session for
+// command. It puts the local terminal into raw mode, forwards SIGWINCH as
+// resize frames and translates Ctrl-C/Ctrl-Z into signal frames instead of
+// tearing down the stream, until the remote command exits.
+func (c *Client) RunInteractive(command string) error {
+	stream, err := c.session.Open()
+	if err != nil {
+		return err
 	}
+	defer stream.Close()
+
+	if err := writeFrame(stream, Frame{Type: "exec", Cmd: command, TTY: true}); err != nil {
+		return err
+	}
+
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, oldState)
+
+	sendWindowSize(stream)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			sendWindowSize(stream)
+		}
+	}()
+
+	go forwardStdin(stream)
+
+	return streamInteractiveOutput(stream)
 }
 
-func (c *Client) ReceiveResponse() {
-	reader := bufio.NewReader(c.connection)
+func sendWindowSize(stream net.Conn) {
+	cols, rows, err := term.GetSize(int(os.Stdin.Fd()))
+	if err != nil {
+		return
+	}
+	writeFrame(stream, Frame{Type: "resize", Rows: rows, Cols: cols})
+}
+
+func forwardStdin(stream net.Conn) {
+	buf := make([]byte, 1)
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Printf("Error while reading response: %v", err)
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			switch buf[0] {
+			case 0x03: // Ctrl-C
+				writeFrame(stream, Frame{Type: "signal", Signal: "SIGINT"})
+			case 0x1a: // Ctrl-Z
+				writeFrame(stream, Frame{Type: "signal", Signal: "SIGTSTP"})
+			default:
+				writeFrame(stream, Frame{Type: "stdin", Data: buf[:n]})
 			}
-			break
+		}
+		if err != nil {
+			writeFrame(stream, Frame{Type: "close"})
+			return
+		}
+	}
+}
+
+func streamInteractiveOutput(stream net.Conn) error {
+	dec := newFrameDecoder(stream)
+	for {
+		frame, err := readFrame(dec)
+		if err != nil {
+			return err
 		}
 
-		// Check for EOF marker
-		if line == "EOF\n" {
-			break
-		} else if line == "TERMINATE\n" {
+		switch frame.Type {
+		case "stdout":
+			os.Stdout.Write(frame.Data)
+		case "stderr":
+			os.Stdout.Write([]byte(ansiRed))
+			os.Stdout.Write(frame.Data)
+			os.Stdout.Write([]byte(ansiReset))
+		case "exit":
+			return nil
+		case "error":
+			log.Printf("Server error: %s", frame.Data)
+			return nil
+		case "timeout":
 			os.Exit(9)
 		}
-
-		fmt.Print(line)
 	}
 }
 
 func (c *Client) Close() {
-	c.connection.Close()
+	c.session.Close()
+	c.conn.Close()
 }
 
 func main() {
@@ -108,17 +270,36 @@ func main() {
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
-		fmt.Print("Enter command (or 'exit' to quit): ")
+		fmt.Print("Enter command (or 'exit' to quit, prefix with '!tty ' for an interactive session): ")
 		scanner.Scan()
 		command := scanner.Text()
 
-		client.SendCommand(command)
-
 		if command == "exit" {
 			fmt.Println("Exiting...")
 			return
 		}
 
-		client.ReceiveResponse()
+		if strings.HasPrefix(command, "!tty ") {
+			if err := client.RunInteractive(strings.TrimPrefix(command, "!tty ")); err != nil {
+				log.Printf("Interactive session ended with error: %v", err)
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		_, stdout, exitCode, err := client.RunCommand(ctx, command)
+		if err != nil {
+			log.Printf("Error running command: %v", err)
+			cancel()
+			continue
+		}
+
+		if _, err := io.Copy(os.Stdout, stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s%s\n", ansiRed, err, ansiReset)
+		}
+		if code := <-exitCode; code != 0 {
+			log.Printf("Command exited with status %d", code)
+		}
+		cancel()
 	}
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteReadFrameRoundTrip(t *testing.T) {
+	want := Frame{Type: "stdin", Data: []byte("ls -la\n")}
+
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, want); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+
+	got, err := readFrame(newFrameDecoder(&buf))
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+
+	if got.Type != want.Type || string(got.Data) != string(want.Data) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEvent is one structured record written to the audit log for every
+// command a principal attempts to run, whether or not it was permitted.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal"`
+	RemoteAddr string    `json:"remote_addr"`
+	Command    string    `json:"command"`
+	Allowed    bool      `json:"allowed"`
+	ExitCode   int       `json:"exit_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditLogger appends AuditEvents as newline-delimited JSON to a file kept
+// separate from the regular application log.
+type AuditLogger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewAuditLogger(path string) (*AuditLogger, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLogger{file: file}, nil
+}
+
+func (a *AuditLogger) Log(event AuditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling audit event: %v", err)
+		return
+	}
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing audit event: %v", err)
+	}
+}
+
+func (a *AuditLogger) Close() error {
+	return a.file.Close()
+}
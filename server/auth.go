@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Principal identifies an authenticated caller, resolved by an
+// Authenticator from the credentials presented on a connection.
+type Principal struct {
+	Name string // client certificate Common Name
+}
+
+// Authenticator resolves the credentials presented on conn to a Principal.
+// The default is CertAuthenticator, which relies on the mTLS handshake
+// already enforced by LoadCertificates.
+type Authenticator interface {
+	Authenticate(conn net.Conn) (*Principal, error)
+}
+
+// Authorizer decides what an authenticated Principal is allowed to do.
+type Authorizer interface {
+	AllowCommand(p *Principal, command string) error
+	AllowChdir(p *Principal, dir string) error
+	AllowInteractive(p *Principal) error
+	Limits(p *Principal) ResourceLimits
+}
+
+// ResourceLimits caps what a principal's commands may consume and where
+// they may run.
+type ResourceLimits struct {
+	CPUSeconds int
+	MemoryMB   int
+	Chdir      string
+}
+
+// CertAuthenticator resolves the principal from the verified mTLS client
+// certificate's Common Name.
+type CertAuthenticator struct{}
+
+func (CertAuthenticator) Authenticate(conn net.Conn) (*Principal, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, fmt.Errorf("connection is not TLS")
+	}
+
+	// The server's TLS config requires and verifies a client certificate
+	// during the handshake, which hasn't run yet on a freshly dispatched
+	// connection; drive it here so ConnectionState is populated.
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no client certificate presented")
+	}
+
+	return &Principal{Name: state.PeerCertificates[0].Subject.CommonName}, nil
+}
+
+// UserPolicy is one entry of the ACL file, keyed by certificate CN.
+type UserPolicy struct {
+	Allow       []string `yaml:"allow"`
+	Deny        []string `yaml:"deny"`
+	CPUSeconds  int      `yaml:"cpu_seconds"`
+	MemoryMB    int      `yaml:"memory_mb"`
+	Chdir       string   `yaml:"chdir"`
+	Interactive bool     `yaml:"interactive"`
+}
+
+// ACLFile is the on-disk shape of the ACL. It's parsed as YAML, which is a
+// superset of JSON, so either format works.
+type ACLFile struct {
+	Users map[string]UserPolicy `yaml:"users"`
+}
+
+type compiledPolicy struct {
+	allow       []*regexp.Regexp
+	deny        []*regexp.Regexp
+	cpuSeconds  int
+	memoryMB    int
+	chdir       string
+	interactive bool
+}
+
+// globToRegexp compiles a shell-style glob (only `*` and `?` are treated as
+// wildcards, everything else is literal) into a regexp that anchors the
+// whole command string. Unlike filepath.Match, `*` here matches `/` too, so
+// a deny glob like "*rm*" can't be bypassed just by the command containing a
+// path.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteByte('^')
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteByte('$')
+	return regexp.Compile(b.String())
+}
+
+// ACLAuthorizer enforces per-principal command, chdir and interactive-mode
+// rules loaded from an ACL file mapping certificate CN to UserPolicy.
+type ACLAuthorizer struct {
+	policies map[string]compiledPolicy
+}
+
+// LoadACL reads and compiles the ACL file at path.
+func LoadACL(path string) (*ACLAuthorizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file ACLFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing ACL file %q: %w", path, err)
+	}
+
+	policies := make(map[string]compiledPolicy, len(file.Users))
+	for name, policy := range file.Users {
+		allow := make([]*regexp.Regexp, 0, len(policy.Allow))
+		for _, pattern := range policy.Allow {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("acl: user %q: invalid allow pattern %q: %w", name, pattern, err)
+			}
+			allow = append(allow, re)
+		}
+
+		deny := make([]*regexp.Regexp, 0, len(policy.Deny))
+		for _, glob := range policy.Deny {
+			re, err := globToRegexp(glob)
+			if err != nil {
+				return nil, fmt.Errorf("acl: user %q: invalid deny glob %q: %w", name, glob, err)
+			}
+			deny = append(deny, re)
+		}
+
+		policies[name] = compiledPolicy{
+			allow:       allow,
+			deny:        deny,
+			cpuSeconds:  policy.CPUSeconds,
+			memoryMB:    policy.MemoryMB,
+			chdir:       policy.Chdir,
+			interactive: policy.Interactive,
+		}
+	}
+
+	return &ACLAuthorizer{policies: policies}, nil
+}
+
+func (a *ACLAuthorizer) policyFor(p *Principal) (compiledPolicy, bool) {
+	policy, ok := a.policies[p.Name]
+	return policy, ok
+}
+
+func (a *ACLAuthorizer) AllowCommand(p *Principal, command string) error {
+	policy, ok := a.policyFor(p)
+	if !ok {
+		return fmt.Errorf("no ACL policy for principal %q", p.Name)
+	}
+
+	for _, re := range policy.deny {
+		if re.MatchString(command) {
+			return fmt.Errorf("command %q denied by ACL for %q", command, p.Name)
+		}
+	}
+
+	for _, re := range policy.allow {
+		if re.MatchString(command) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("command %q not permitted for %q", command, p.Name)
+}
+
+func (a *ACLAuthorizer) AllowChdir(p *Principal, dir string) error {
+	policy, ok := a.policyFor(p)
+	if !ok {
+		return fmt.Errorf("no ACL policy for principal %q", p.Name)
+	}
+	if policy.chdir == "" {
+		return nil
+	}
+	if dir != policy.chdir && !strings.HasPrefix(dir, policy.chdir+"/") {
+		return fmt.Errorf("chdir to %q denied for %q", dir, p.Name)
+	}
+	return nil
+}
+
+func (a *ACLAuthorizer) AllowInteractive(p *Principal) error {
+	policy, ok := a.policyFor(p)
+	if !ok {
+		return fmt.Errorf("no ACL policy for principal %q", p.Name)
+	}
+	if !policy.interactive {
+		return fmt.Errorf("interactive sessions denied for %q", p.Name)
+	}
+	return nil
+}
+
+func (a *ACLAuthorizer) Limits(p *Principal) ResourceLimits {
+	policy := a.policies[p.Name]
+	return ResourceLimits{
+		CPUSeconds: policy.cpuSeconds,
+		MemoryMB:   policy.memoryMB,
+		Chdir:      policy.chdir,
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		command string
+		want    bool
+	}{
+		{"*rm*", "sudo rm -rf /etc", true},
+		{"*rm*", "rm", true},
+		{"*rm*", "ls -la", false},
+		{"ls*", "ls -la /etc", true},
+		{"ls*", "sudo ls", false},
+		{"echo ?", "echo a", true},
+		{"echo ?", "echo ab", false},
+	}
+
+	for _, tt := range tests {
+		re, err := globToRegexp(tt.glob)
+		if err != nil {
+			t.Fatalf("globToRegexp(%q): %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.command); got != tt.want {
+			t.Errorf("globToRegexp(%q).MatchString(%q) = %v, want %v", tt.glob, tt.command, got, tt.want)
+		}
+	}
+}
+
+func newTestAuthorizer(t *testing.T, name string, policy compiledPolicy) *ACLAuthorizer {
+	t.Helper()
+	return &ACLAuthorizer{policies: map[string]compiledPolicy{name: policy}}
+}
+
+func TestAllowCommandDenyOverridesAllow(t *testing.T) {
+	denyRe, err := globToRegexp("*rm*")
+	if err != nil {
+		t.Fatalf("globToRegexp: %v", err)
+	}
+
+	a := newTestAuthorizer(t, "alice", compiledPolicy{
+		allow: []*regexp.Regexp{regexp.MustCompile(".*")},
+		deny:  []*regexp.Regexp{denyRe},
+	})
+
+	p := &Principal{Name: "alice"}
+	if err := a.AllowCommand(p, "sudo rm -rf /etc"); err == nil {
+		t.Error("AllowCommand: expected the deny glob to still deny a command containing a path")
+	}
+	if err := a.AllowCommand(p, "ls -la"); err != nil {
+		t.Errorf("AllowCommand: unexpected denial for an allowed command: %v", err)
+	}
+}
+
+func TestAllowCommandRejectsUnknownPrincipal(t *testing.T) {
+	a := newTestAuthorizer(t, "alice", compiledPolicy{})
+	if err := a.AllowCommand(&Principal{Name: "mallory"}, "ls"); err == nil {
+		t.Error("AllowCommand: expected an error for a principal with no ACL policy")
+	}
+}
+
+func TestAllowCommandRejectsCommandNotInAllowList(t *testing.T) {
+	a := newTestAuthorizer(t, "alice", compiledPolicy{
+		allow: []*regexp.Regexp{regexp.MustCompile("^ls")},
+	})
+	if err := a.AllowCommand(&Principal{Name: "alice"}, "rm -rf /"); err == nil {
+		t.Error("AllowCommand: expected a command outside the allow list to be denied")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Frame is one newline-delimited JSON message exchanged between Client and
+// Server over a stream. It replaces the earlier plain-text protocol and its
+// "EOF\n"/"TERMINATE\n" sentinels with something that's easy to log and
+// inspect by hand.
+//
+// Client -> server frame types: "exec", "stdin", "resize", "signal", "close".
+// Server -> client frame types: "stdout", "stderr", "exit", "error", "timeout".
+type Frame struct {
+	Type      string            `json:"type"`
+	Data      []byte            `json:"data,omitempty"` // stdout/stderr/stdin bytes; base64 via json
+	ExitCode  *int              `json:"exit_code,omitempty"`
+	Signal    string            `json:"signal,omitempty"`
+	Cmd       string            `json:"cmd,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Cwd       string            `json:"cwd,omitempty"`
+	TimeoutMs int               `json:"timeout_ms,omitempty"`
+	TTY       bool              `json:"tty,omitempty"`
+	Rows      int               `json:"rows,omitempty"`
+	Cols      int               `json:"cols,omitempty"`
+}
+
+// writeFrame NDJSON-encodes frame and writes it to w, terminated by a
+// newline.
+func writeFrame(w io.Writer, frame Frame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// newFrameDecoder returns a decoder for successive Frames read from r. It
+// must be kept and reused for the lifetime of the stream: json.Decoder
+// buffers ahead of each decoded value, so creating a fresh decoder per read
+// would silently drop already-buffered bytes.
+func newFrameDecoder(r io.Reader) *json.Decoder {
+	return json.NewDecoder(r)
+}
+
+func readFrame(dec *json.Decoder) (Frame, error) {
+	var frame Frame
+	err := dec.Decode(&frame)
+	return frame, err
+}
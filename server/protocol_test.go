@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteReadFrameRoundTrip(t *testing.T) {
+	exitCode := 7
+	want := Frame{Type: "exit", ExitCode: &exitCode}
+
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, want); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+
+	got, err := readFrame(newFrameDecoder(&buf))
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+
+	if got.Type != want.Type {
+		t.Errorf("Type = %q, want %q", got.Type, want.Type)
+	}
+	if got.ExitCode == nil || *got.ExitCode != exitCode {
+		t.Errorf("ExitCode = %v, want %d", got.ExitCode, exitCode)
+	}
+}
+
+func TestReadFrameDecodesSuccessiveFrames(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, Frame{Type: "stdout", Data: []byte("hello ")})
+	writeFrame(&buf, Frame{Type: "stdout", Data: []byte("world")})
+	writeFrame(&buf, Frame{Type: "exit"})
+
+	dec := newFrameDecoder(&buf)
+
+	var got []byte
+	for {
+		frame, err := readFrame(dec)
+		if err != nil {
+			t.Fatalf("readFrame: %v", err)
+		}
+		if frame.Type == "exit" {
+			break
+		}
+		got = append(got, frame.Data...)
+	}
+
+	if string(got) != "hello world" {
+		t.Errorf("assembled data = %q, want %q", got, "hello world")
+	}
+}
+
+func TestReadFrameOnEmptyStreamReturnsError(t *testing.T) {
+	_, err := readFrame(newFrameDecoder(&bytes.Buffer{}))
+	if err == nil {
+		t.Fatal("readFrame on an empty stream: expected an error, got nil")
+	}
+}
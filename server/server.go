@@ -5,6 +5,8 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -12,25 +14,37 @@ import (
 	"os/exec"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/creack/pty"
+	"github.com/hashicorp/yamux"
 )
 
 const (
-	host           = "localhost"
-	port           = "12345"
-	connectionType = "tcp"
-	certFile       = "./cert/server-cert.pem"
-	keyFile        = "./cert/server-key.pem"
-	caFile         = "./cert/ca-cert.pem"
-	timeout        = 100 * time.Second // client timeouts after 100 seconds from connection start
+	host             = "localhost"
+	port             = "12345"
+	connectionType   = "tcp"
+	certFile         = "./cert/server-cert.pem"
+	keyFile          = "./cert/server-key.pem"
+	caFile           = "./cert/ca-cert.pem"
+	aclFile          = "./acl.yaml"
+	auditLogFile     = "./audit.log"
+	timeout          = 100 * time.Second // client timeouts after 100 seconds from connection start
+	handshakeTimeout = 5 * time.Second   // time allowed to sniff a new connection's first bytes
+	shutdownGrace    = 5 * time.Second   // time a command's process group gets after SIGTERM before SIGKILL
 )
 
 type Server struct {
-	wg         sync.WaitGroup
-	listener   net.Listener
-	shutdown   chan struct{}
-	connection chan net.Conn
+	wg            sync.WaitGroup
+	listener      net.Listener
+	tlsConfig     *tls.Config
+	shutdown      chan struct{}
+	connection    chan net.Conn
+	authenticator Authenticator
+	authorizer    Authorizer
+	audit         *AuditLogger
 }
 
 func NewServer(address string) (*Server, error) {
@@ -39,16 +53,33 @@ func NewServer(address string) (*Server, error) {
 		return nil, err
 	}
 
-	listener, err := tls.Listen(connectionType, address, tlsConfig)
+	// A raw listener is used instead of tls.Listen so that AcceptConnections
+	// can peek at each connection's first bytes and reject anything that
+	// isn't a TLS ClientHello before it ties up a handleConnection goroutine.
+	listener, err := net.Listen(connectionType, address)
 	if err != nil {
 		return nil, err
 	}
 	log.Println("Server is listening on:", address)
 
+	authorizer, err := LoadACL(aclFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading ACL: %w", err)
+	}
+
+	audit, err := NewAuditLogger(auditLogFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+
 	return &Server{
-		listener:   listener,
-		shutdown:   make(chan struct{}),
-		connection: make(chan net.Conn),
+		listener:      listener,
+		tlsConfig:     tlsConfig,
+		shutdown:      make(chan struct{}),
+		connection:    make(chan net.Conn),
+		authenticator: CertAuthenticator{},
+		authorizer:    authorizer,
+		audit:         audit,
 	}, nil
 }
 
@@ -77,20 +108,98 @@ func (s *Server) AcceptConnections() {
 	defer s.wg.Done()
 
 	for {
-		select {
-		case <-s.shutdown:
-			return
-		default:
-			conn, err := s.listener.Accept()
-			if err != nil {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.shutdown:
+				// Stop() closed the listener to unblock this Accept; don't
+				// spin logging that error forever.
+				return
+			default:
+				log.Printf("Error accepting connection: %v", err)
 				continue
 			}
-			log.Println("New connection established from:", conn.RemoteAddr())
-			s.connection <- conn
 		}
+		log.Println("New connection established from:", conn.RemoteAddr())
+		// Sniffing the connection involves a read, which a slow or
+		// malicious client could stall forever, so it happens in its own
+		// goroutine rather than blocking the accept loop. It's tracked in
+		// s.wg from here: dispatchConnection hands the connection off to
+		// handleConnections, which carries the same Done() forward.
+		s.wg.Add(1)
+		go s.dispatchConnection(conn)
 	}
 }
 
+// dispatchConnection peeks at a freshly accepted connection's first bytes to
+// confirm it looks like a TLS ClientHello, drops it early otherwise, and
+// hands the rest off to handleConnections. The peeked bytes are preserved
+// for the eventual handler via the buffered reader.
+//
+// This intentionally does not fall back to a plaintext control channel for
+// non-TLS connections: CertAuthenticator only ever resolves a principal from
+// a verified client certificate, so a plaintext connection could never pass
+// authentication anyway, and accepting one just to fail it later would
+// leave an easy unauthenticated foothold for slowloris-style abuse. A
+// plaintext debug path would need its own Authenticator (e.g. a
+// short-lived shared secret) and ACL entries before it could be exposed
+// safely; until that exists, non-TLS connections are rejected outright
+// rather than half-supported.
+//
+// The read deadline set below stays in force past the peek and across the
+// TLS handshake, which happens lazily inside CertAuthenticator.Authenticate
+// once handleConnection picks the connection up; it's only cleared there
+// once authentication completes, so a client that stalls mid-handshake
+// still gets dropped instead of parking a goroutine forever.
+func (s *Server) dispatchConnection(raw net.Conn) {
+	raw.SetReadDeadline(time.Now().Add(handshakeTimeout))
+
+	reader := bufio.NewReader(raw)
+	header, err := reader.Peek(3)
+	if err != nil {
+		log.Printf("Error sniffing connection from %s: %v", raw.RemoteAddr(), err)
+		raw.Close()
+		s.wg.Done()
+		return
+	}
+
+	if !looksLikeTLSHandshake(header) {
+		log.Printf("Rejecting non-TLS connection from %s", raw.RemoteAddr())
+		raw.Close()
+		s.wg.Done()
+		return
+	}
+
+	peeked := &peekedConn{Conn: raw, reader: reader}
+	conn := tls.Server(peeked, s.tlsConfig)
+
+	select {
+	case <-s.shutdown:
+		conn.Close()
+		s.wg.Done()
+	case s.connection <- conn:
+		// handleConnections takes ownership of the Done() call from here.
+	}
+}
+
+// looksLikeTLSHandshake reports whether header, the first bytes read from a
+// connection, look like the start of a TLS record carrying a ClientHello:
+// content type 0x16 (handshake) and a 0x03xx (SSLv3/TLS) version.
+func looksLikeTLSHandshake(header []byte) bool {
+	return len(header) >= 3 && header[0] == 0x16 && header[1] == 0x03
+}
+
+// peekedConn lets the bytes consumed while sniffing a connection's protocol
+// be replayed to whatever handles the connection afterwards.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
 func (s *Server) handleConnections() {
 	defer s.wg.Done()
 
@@ -104,88 +213,500 @@ func (s *Server) handleConnections() {
 	}
 }
 
+// handleConnection multiplexes a single authenticated TLS connection into
+// many independent streams via yamux, so one client can run several
+// commands concurrently instead of serializing them one-per-connection.
 func (s *Server) handleConnection(conn net.Conn) {
+	defer s.wg.Done()
 	defer conn.Close()
 
+	principal, err := s.authenticator.Authenticate(conn)
+	// The handshake deadline dispatchConnection set stays in force through
+	// the TLS handshake Authenticate just drove; clear it now so it doesn't
+	// also cut off legitimate long-lived commands on this connection.
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("Authentication failed for %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	session, err := yamux.Server(conn, yamux.DefaultConfig())
+	if err != nil {
+		log.Printf("Error establishing mux session with %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer session.Close()
+
+	// streams tracks every stream currently in flight on this session so a
+	// session timeout can notify each of them directly: the client only
+	// ever Accept()s frames on streams it opened itself, so a
+	// server-opened notification stream would go unread.
+	streams := newStreamSet()
+
+	// ctx bounds every command run over this session: it expires on its own
+	// after timeout, or is canceled early by Stop(), either way unblocking
+	// session.Accept() below and telling in-flight commands in handleStream
+	// to wind down.
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.shutdown:
+			cancel()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			s.handleSessionTimeout(session, streams)
+		} else {
+			session.Close()
+		}
+	}()
 
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
+	for {
 		select {
 		case <-s.shutdown:
 			return
-		case <-ctx.Done():
-			// The client session timed out, close the connection and exit
-			s.handleTimeout(conn)
-			return
 		default:
-			// Continue handling commands
-			command := scanner.Text()
-			if command == "exit" {
-				log.Printf("Connection from %s closed\n", conn.RemoteAddr())
-				return
-			}
+		}
 
-			// Execute the command and send output to the client
-			if err := s.executeCommand(conn, command); err != nil {
-				log.Printf("Error executing command: %v\n", err)
-				conn.Write([]byte("Error executing command:" + err.Error() + "\n"))
-				conn.Write([]byte("EOF\n"))
+		stream, err := session.Accept()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Mux session with %s closed: %v", conn.RemoteAddr(), err)
 			}
+			return
+		}
+
+		streams.add(stream)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer streams.remove(stream)
+			s.handleStream(ctx, stream, conn.RemoteAddr(), principal)
+		}()
+	}
+}
 
+// handleSessionTimeout notifies every stream currently in flight on session
+// that its duration was exceeded and tears the session down. It writes
+// directly to each open stream rather than opening a new one, since the
+// client never accepts server-opened streams.
+func (s *Server) handleSessionTimeout(session *yamux.Session, streams *streamSet) {
+	log.Println("Client session duration exceeded, closing session")
+	streams.notifyTimeout()
+	session.Close()
+}
+
+// streamSet tracks the net.Conn streams currently in flight on a mux
+// session so a session-level event, such as a timeout, can be delivered to
+// each of them directly.
+type streamSet struct {
+	mu      sync.Mutex
+	streams map[net.Conn]struct{}
+}
+
+func newStreamSet() *streamSet {
+	return &streamSet{streams: make(map[net.Conn]struct{})}
+}
+
+func (s *streamSet) add(stream net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[stream] = struct{}{}
+}
+
+func (s *streamSet) remove(stream net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, stream)
+}
+
+// notifyTimeout writes a "timeout" frame to every currently tracked stream.
+func (s *streamSet) notifyTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stream := range s.streams {
+		writeFrame(stream, Frame{Type: "timeout"})
+	}
+}
+
+// handleStream services a single logical stream: it carries exactly one
+// command along with its own stdin, stdout, stderr and exit code. Every
+// command is checked against the principal's ACL and recorded to the audit
+// log, whether it was allowed or denied.
+func (s *Server) handleStream(ctx context.Context, stream net.Conn, remoteAddr net.Addr, principal *Principal) {
+	defer stream.Close()
+
+	dec := newFrameDecoder(stream)
+	frame, err := readFrame(dec)
+	if err != nil {
+		if err != io.EOF {
+			log.Println("Error while reading incoming frame:", err)
 		}
+		return
+	}
+
+	if frame.Type != "exec" {
+		log.Printf("Unexpected frame type %q at the start of a stream", frame.Type)
+		return
 	}
 
-	scannerErr := scanner.Err()
-	if scannerErr != nil {
-		log.Println("Error while reading incoming message:", scannerErr)
+	command := frame.Cmd
+
+	if frame.TTY {
+		if err := s.authorizer.AllowInteractive(principal); err != nil {
+			s.denyAndAudit(stream, remoteAddr, principal, command, err)
+			return
+		}
+	}
+	if err := s.authorizer.AllowCommand(principal, command); err != nil {
+		s.denyAndAudit(stream, remoteAddr, principal, command, err)
+		return
 	}
+	if frame.Cwd != "" {
+		if err := s.authorizer.AllowChdir(principal, frame.Cwd); err != nil {
+			s.denyAndAudit(stream, remoteAddr, principal, command, err)
+			return
+		}
+	}
+
+	limits := s.authorizer.Limits(principal)
+	if frame.Cwd != "" {
+		limits.Chdir = frame.Cwd
+	}
+
+	event := AuditEvent{
+		Time:       time.Now(),
+		Principal:  principal.Name,
+		RemoteAddr: remoteAddr.String(),
+		Command:    command,
+		Allowed:    true,
+	}
+
+	exitCode, err := s.executeCommand(ctx, stream, dec, frame, limits)
+	event.ExitCode = exitCode
+	if err != nil {
+		log.Printf("Error executing command: %v\n", err)
+		writeFrame(stream, Frame{Type: "error", Data: []byte(err.Error())})
+		event.Error = err.Error()
+	}
+	s.audit.Log(event)
 }
 
-func (s *Server) handleTimeout(conn net.Conn) {
-	conn.Write([]byte("Client session duration exceeded. Disconnecting...\n"))
-	conn.Write([]byte("TERMINATE\n"))
-	log.Printf("Client (%s) session duration exceeded\n", conn.RemoteAddr())
+// denyAndAudit rejects a command that failed an ACL check, telling the
+// client why and recording the denial to the audit log.
+func (s *Server) denyAndAudit(stream net.Conn, remoteAddr net.Addr, principal *Principal, command string, reason error) {
+	log.Printf("Denying command from %s (%s): %v", principal.Name, remoteAddr, reason)
+	writeFrame(stream, Frame{Type: "error", Data: []byte(reason.Error())})
+	s.audit.Log(AuditEvent{
+		Time:       time.Now(),
+		Principal:  principal.Name,
+		RemoteAddr: remoteAddr.String(),
+		Command:    command,
+		Allowed:    false,
+		Error:      reason.Error(),
+	})
 }
 
-func (s *Server) executeCommand(conn net.Conn, command string) error {
-	cmd := exec.Command("sh", "-c", command)
+func (s *Server) executeCommand(ctx context.Context, conn net.Conn, dec *json.Decoder, frame Frame, limits ResourceLimits) (int, error) {
+	if frame.TTY {
+		return s.executeInteractiveCommand(ctx, conn, dec, frame, limits)
+	}
 
-	// Set CPU time limit to 5 seconds
+	cpuSeconds := limits.CPUSeconds
+	if cpuSeconds <= 0 {
+		cpuSeconds = 5 // default CPU time limit
+	}
+	ulimitScript := fmt.Sprintf("ulimit -t %d", cpuSeconds)
+	if limits.MemoryMB > 0 {
+		ulimitScript += fmt.Sprintf("; ulimit -v %d", limits.MemoryMB*1024)
+	}
+	ulimitScript += `; exec "$@"`
+
+	cmd := exec.Command("sh", "-c", frame.Cmd)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
-	ulimitCmd := exec.Command("sh", "-c", "ulimit -t 5; exec \"$@\"", "--", command)
+	ulimitCmd := exec.Command("sh", "-c", ulimitScript, "--", frame.Cmd)
 	ulimitCmd.SysProcAttr = cmd.SysProcAttr
+	ulimitCmd.Dir = limits.Chdir
+	if len(frame.Env) > 0 {
+		ulimitCmd.Env = os.Environ()
+		for k, v := range frame.Env {
+			ulimitCmd.Env = append(ulimitCmd.Env, k+"="+v)
+		}
+	}
 
-	outputPipe, err := ulimitCmd.StdoutPipe()
+	stdinPipe, err := ulimitCmd.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	stdoutPipe, err := ulimitCmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	stderrPipe, err := ulimitCmd.StderrPipe()
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	var timedOut atomic.Bool
+	if frame.TimeoutMs > 0 {
+		timer := time.AfterFunc(time.Duration(frame.TimeoutMs)*time.Millisecond, func() {
+			timedOut.Store(true)
+			ulimitCmd.Process.Kill()
+		})
+		defer timer.Stop()
 	}
 
 	if err := ulimitCmd.Start(); err != nil {
-		return err
+		return 0, err
 	}
 
+	commandDone := make(chan struct{})
+	defer close(commandDone)
 	go func() {
-		defer outputPipe.Close()
-		_, err := io.Copy(conn, outputPipe)
-		if err != nil {
-			log.Printf("Error sending command output to client: %v", err)
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(ulimitCmd.Process, shutdownGrace)
+		case <-commandDone:
 		}
 	}()
 
+	go forwardStdinFrames(dec, stdinPipe)
+
+	var streams sync.WaitGroup
+	streams.Add(2)
+	go streamOutputFrames(&streams, conn, "stdout", stdoutPipe)
+	go streamOutputFrames(&streams, conn, "stderr", stderrPipe)
+	streams.Wait()
+
 	if err := ulimitCmd.Wait(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return exitErr
+		if timedOut.Load() {
+			return 0, writeFrame(conn, Frame{Type: "timeout"})
+		}
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return 0, err
 		}
+		exitCode := exitErr.ExitCode()
+		return exitCode, writeFrame(conn, Frame{Type: "exit", ExitCode: &exitCode})
 	}
 
-	// Mark the end of the output
-	conn.Write([]byte("\nEOF\n"))
+	exitCode := 0
+	return exitCode, writeFrame(conn, Frame{Type: "exit", ExitCode: &exitCode})
+}
+
+// forwardStdinFrames copies "stdin" frame payloads read from dec to w until a
+// "close" frame arrives or the stream errors out, then closes w so the
+// child sees EOF on its stdin.
+func forwardStdinFrames(dec *json.Decoder, w io.WriteCloser) {
+	defer w.Close()
+
+	for {
+		frame, err := readFrame(dec)
+		if err != nil {
+			return
+		}
 
-	return nil
+		switch frame.Type {
+		case "stdin":
+			w.Write(frame.Data)
+		case "close":
+			return
+		}
+	}
+}
+
+// streamOutputFrames copies r to conn, wrapping each chunk read in a frame of
+// the given type, until r is exhausted.
+func streamOutputFrames(wg *sync.WaitGroup, conn net.Conn, typ string, r io.Reader) {
+	defer wg.Done()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := writeFrame(conn, Frame{Type: typ, Data: buf[:n]}); werr != nil {
+				log.Printf("Error sending command output to client: %v", werr)
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading command output: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// executeInteractiveCommand allocates a pseudo-terminal for the command so
+// that programs which need a controlling TTY (vim, top, sudo, ...) can run
+// over the connection. It runs the command through the same ulimit wrapper
+// as executeCommand so the principal's CPU/memory limits still apply, and
+// honors frame.TimeoutMs the same way. Stdin, window resize and signal
+// frames from the client are applied to the pty until the child exits.
+func (s *Server) executeInteractiveCommand(ctx context.Context, conn net.Conn, dec *json.Decoder, frame Frame, limits ResourceLimits) (int, error) {
+	cpuSeconds := limits.CPUSeconds
+	if cpuSeconds <= 0 {
+		cpuSeconds = 5 // default CPU time limit
+	}
+	ulimitScript := fmt.Sprintf("ulimit -t %d", cpuSeconds)
+	if limits.MemoryMB > 0 {
+		ulimitScript += fmt.Sprintf("; ulimit -v %d", limits.MemoryMB*1024)
+	}
+	ulimitScript += `; exec "$@"`
+
+	cmd := exec.Command("sh", "-c", ulimitScript, "--", frame.Cmd)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	cmd.Dir = limits.Chdir
+	if len(frame.Env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range frame.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return 0, err
+	}
+	defer ptmx.Close()
+
+	var timedOut atomic.Bool
+	if frame.TimeoutMs > 0 {
+		timer := time.AfterFunc(time.Duration(frame.TimeoutMs)*time.Millisecond, func() {
+			timedOut.Store(true)
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+
+	commandDone := make(chan struct{})
+	defer close(commandDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(cmd.Process, shutdownGrace)
+		case <-commandDone:
+		}
+	}()
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := ptmx.Read(buf)
+			if n > 0 {
+				if werr := writeFrame(conn, Frame{Type: "stdout", Data: buf[:n]}); werr != nil {
+					log.Printf("Error sending pty output to client: %v", werr)
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Frames are read on their own goroutine so the loop below can react to
+	// outputDone as soon as the child exits, instead of only noticing it
+	// between blocking readFrame calls: a short-lived command would
+	// otherwise leave the client waiting forever for an "exit" frame that
+	// never comes because readFrame is still parked waiting on the next
+	// client frame.
+	//
+	// loopDone is closed when the select loop below returns, so the reader
+	// goroutine can give up on delivering a frame it already decoded
+	// instead of blocking forever on a send nobody will ever receive.
+	loopDone := make(chan struct{})
+	defer close(loopDone)
+
+	frames := make(chan Frame)
+	frameErrs := make(chan error, 1)
+	go func() {
+		for {
+			ctrl, err := readFrame(dec)
+			if err != nil {
+				select {
+				case frameErrs <- err:
+				case <-loopDone:
+				}
+				return
+			}
+			select {
+			case frames <- ctrl:
+			case <-loopDone:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-outputDone:
+			if err := cmd.Wait(); err != nil {
+				if timedOut.Load() {
+					return 0, writeFrame(conn, Frame{Type: "timeout"})
+				}
+				exitCode := 0
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					exitCode = exitErr.ExitCode()
+				}
+				return exitCode, writeFrame(conn, Frame{Type: "exit", ExitCode: &exitCode})
+			}
+			exitCode := 0
+			return exitCode, writeFrame(conn, Frame{Type: "exit", ExitCode: &exitCode})
+		case err := <-frameErrs:
+			cmd.Process.Kill()
+			return 0, err
+		case ctrl := <-frames:
+			switch ctrl.Type {
+			case "stdin":
+				if _, werr := ptmx.Write(ctrl.Data); werr != nil {
+					log.Printf("Error writing stdin to pty: %v", werr)
+				}
+			case "resize":
+				pty.Setsize(ptmx, &pty.Winsize{Rows: uint16(ctrl.Rows), Cols: uint16(ctrl.Cols)})
+			case "signal":
+				if sig := parseSignal(ctrl.Signal); sig != nil {
+					cmd.Process.Signal(sig)
+				}
+			case "close":
+				ptmx.Close()
+			default:
+				log.Printf("Unexpected frame type %q during interactive session", ctrl.Type)
+			}
+		}
+	}
+}
+
+// terminateProcessGroup asks the process group led by proc to exit with
+// SIGTERM, then escalates to SIGKILL if it hasn't exited within grace. Since
+// every command is started with Setpgid: true, proc.Pid is also its process
+// group id, so signaling -proc.Pid reaches any children it spawned too.
+func terminateProcessGroup(proc *os.Process, grace time.Duration) {
+	syscall.Kill(-proc.Pid, syscall.SIGTERM)
+	time.AfterFunc(grace, func() {
+		syscall.Kill(-proc.Pid, syscall.SIGKILL)
+	})
+}
+
+func parseSignal(name string) os.Signal {
+	switch name {
+	case "SIGINT":
+		return syscall.SIGINT
+	case "SIGTSTP":
+		return syscall.SIGTSTP
+	case "SIGTERM":
+		return syscall.SIGTERM
+	default:
+		return nil
+	}
 }
 
 func (s *Server) Start() {
@@ -194,9 +715,14 @@ func (s *Server) Start() {
 	go s.handleConnections()
 }
 
-func (s *Server) Stop() {
+// Stop stops accepting new connections and commands, sends every command
+// still running SIGTERM right away (escalating to SIGKILL after
+// shutdownGrace), then waits for in-flight work to drain. Once ctx is done,
+// Stop returns ctx.Err() without waiting further.
+func (s *Server) Stop(ctx context.Context) error {
 	close(s.shutdown)
 	s.listener.Close()
+	defer s.audit.Close()
 
 	done := make(chan struct{})
 	go func() {
@@ -206,10 +732,10 @@ func (s *Server) Stop() {
 
 	select {
 	case <-done:
-		return
-	case <-time.After(time.Second):
-		log.Println("Connection timed out")
-		return
+		return nil
+	case <-ctx.Done():
+		log.Println("Shutdown deadline reached with commands still running")
+		return ctx.Err()
 	}
 }
 
@@ -232,6 +758,11 @@ func main() {
 	<-sigChan
 
 	log.Println("Server will shut down")
-	server.Stop()
+	stopCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace*2)
+	defer cancel()
+	if err := server.Stop(stopCtx); err != nil {
+		log.Printf("Server shut down with commands still draining: %v", err)
+		return
+	}
 	log.Println("Server is shut down")
 }
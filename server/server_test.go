@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestLooksLikeTLSHandshake(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   bool
+	}{
+		{"TLS 1.2 ClientHello", []byte{0x16, 0x03, 0x03}, true},
+		{"TLS 1.0 ClientHello", []byte{0x16, 0x03, 0x01}, true},
+		{"plaintext text", []byte("GET"), false},
+		{"SSH banner", []byte("SSH"), false},
+		{"too short", []byte{0x16, 0x03}, false},
+		{"empty", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := looksLikeTLSHandshake(tt.header); got != tt.want {
+				t.Errorf("looksLikeTLSHandshake(%v) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}